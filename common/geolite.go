@@ -1,16 +1,45 @@
 package common
 
 import (
+	"bytes"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/elastic/libbeat/logp"
 
 	"github.com/nranchev/go-libGeoIP"
+	"github.com/oschwald/geoip2-golang"
 )
 
 type GeoIp struct {
 	Paths []string
+	// ASNPath points at a separate GeoLite2-ASN.mmdb database. Only used
+	// when the primary database is in MaxMind DB format.
+	ASNPath string
+}
+
+// GeoIPRecord is the normalized result of a GeoIP lookup, populated from
+// whichever backend (legacy GeoIP.dat or MaxMind .mmdb) handled the query.
+// Fields the backend or database edition doesn't provide are left zero.
+type GeoIPRecord struct {
+	CountryCode    string
+	CountryName    string
+	City           string
+	Subdivisions   []string
+	Latitude       float64
+	Longitude      float64
+	AccuracyRadius uint16
+	ASN            uint
+	Organization   string
+}
+
+// GeoIPLookup enriches an IP address with geo data. It's implemented by a
+// legacy libGeoIP backend and a MaxMind GeoIP2 (.mmdb) backend, chosen by
+// LoadGeoIPData based on the configured database file.
+type GeoIPLookup interface {
+	Lookup(ip net.IP) *GeoIPRecord
 }
 
 // TODO(shutej): Don't hard-code unix paths.  This could be a platform-specific
@@ -18,9 +47,18 @@ type GeoIp struct {
 var geoipPaths = []string{
 	"/usr/share/GeoIP/GeoIP.dat",
 	"/usr/local/var/GeoIP/GeoIP.dat",
+	"/usr/share/GeoIP/GeoLite2-City.mmdb",
+	"/usr/share/GeoIP/GeoLite2-Country.mmdb",
+}
+
+// geoipASNPaths is searched for a GeoIP2 ASN database when config.ASNPath
+// isn't set, the same way geoipPaths is searched for the main database.
+var geoipASNPaths = []string{
+	"/usr/share/GeoIP/GeoLite2-ASN.mmdb",
+	"/usr/local/var/GeoIP/GeoLite2-ASN.mmdb",
 }
 
-func LoadGeoIPData(config GeoIp) *libgeo.GeoIP {
+func LoadGeoIPData(config GeoIp) GeoIPLookup {
 	if config.Paths != nil {
 		geoipPaths = config.Paths
 	}
@@ -29,37 +67,198 @@ func LoadGeoIPData(config GeoIp) *libgeo.GeoIP {
 		return nil
 	}
 
-	// look for the first existing path
-	var geoipPath string
-	for _, path := range geoipPaths {
+	geoipPath := findExistingPath(geoipPaths)
+	if len(geoipPath) == 0 {
+		logp.Warn("Couldn't load GeoIP database")
+		return nil
+	}
+
+	if !isMMDBPath(geoipPath) {
+		return loadLegacyGeoIP(geoipPath)
+	}
+
+	asnPath := config.ASNPath
+	if asnPath == "" {
+		asnPath = findExistingPath(geoipASNPaths)
+	}
+	return loadGeoIP2(geoipPath, asnPath)
+}
+
+// findExistingPath returns the first path in paths that exists, resolving
+// it if it's a symlink, or "" if none of them do.
+func findExistingPath(paths []string) string {
+	for _, path := range paths {
 		fi, err := os.Lstat(path)
 		if err != nil {
 			continue
 		}
 
 		if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
-			// follow symlink
-			geoipPath, err = filepath.EvalSymlinks(path)
+			resolved, err := filepath.EvalSymlinks(path)
 			if err != nil {
-				logp.Warn("Could not load GeoIP data: %s", err.Error())
-				return nil
+				logp.Warn("Could not resolve %s: %s", path, err.Error())
+				continue
 			}
-		} else {
-			geoipPath = path
+			return resolved
 		}
-		break
+		return path
 	}
+	return ""
+}
 
-	if len(geoipPath) == 0 {
-		logp.Warn("Couldn't load GeoIP database")
-		return nil
+// mmdbMetadataMarker is the byte sequence a MaxMind DB reader looks for to
+// find the metadata section every .mmdb file ends with; see the MaxMind DB
+// file format spec.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxMMDBMetadataSearch bounds how much of the file's tail isMMDBPath scans
+// for mmdbMetadataMarker, since the metadata section is small and always
+// near the end.
+const maxMMDBMetadataSearch = 128 * 1024
+
+// isMMDBPath tells a MaxMind DB apart from the legacy libGeoIP .dat format.
+// It trusts the ".mmdb" extension every current GeoLite2 distribution
+// ships with, and falls back to sniffing for the metadata section marker
+// MaxMind DB files carry near the end, for databases that don't use that
+// extension.
+func isMMDBPath(path string) bool {
+	if strings.HasSuffix(strings.ToLower(path), ".mmdb") {
+		return true
+	}
+	return hasMMDBMetadataMarker(path)
+}
+
+func hasMMDBMetadataMarker(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
 	}
+	defer f.Close()
 
-	geoLite, err := libgeo.Load(geoipPath)
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	size := fi.Size()
+	readSize := int64(maxMMDBMetadataSearch)
+	if size < readSize {
+		readSize = size
+	}
+
+	buf := make([]byte, readSize)
+	if _, err := f.ReadAt(buf, size-readSize); err != nil {
+		return false
+	}
+
+	return bytes.Contains(buf, mmdbMetadataMarker)
+}
+
+func loadLegacyGeoIP(path string) GeoIPLookup {
+	geoLite, err := libgeo.Load(path)
 	if err != nil {
 		logp.Warn("Could not load GeoIP data: %s", err.Error())
+		return nil
+	}
+
+	logp.Info("Loaded GeoIP data from: %s", path)
+	return &legacyGeoIPLookup{db: geoLite}
+}
+
+func loadGeoIP2(path string, asnPath string) GeoIPLookup {
+	city, err := geoip2.Open(path)
+	if err != nil {
+		logp.Warn("Could not load GeoIP2 data: %s", err.Error())
+		return nil
+	}
+	logp.Info("Loaded GeoIP2 data from: %s", path)
+
+	lookup := &geoIP2Lookup{city: city}
+
+	if asnPath != "" {
+		asn, err := geoip2.Open(asnPath)
+		if err != nil {
+			logp.Warn("Could not load GeoIP2 ASN data: %s", err.Error())
+		} else {
+			logp.Info("Loaded GeoIP2 ASN data from: %s", asnPath)
+			lookup.asn = asn
+		}
+	}
+
+	return lookup
+}
+
+type legacyGeoIPLookup struct {
+	db *libgeo.GeoIP
+}
+
+func (l *legacyGeoIPLookup) Lookup(ip net.IP) *GeoIPRecord {
+	loc := l.db.GetLocationByIP(ip.String())
+	if loc == nil {
+		return nil
+	}
+
+	return &GeoIPRecord{
+		CountryCode: loc.CountryCode,
+		CountryName: loc.CountryName,
+		Latitude:    loc.Latitude,
+		Longitude:   loc.Longitude,
 	}
+}
+
+// geoIP2Lookup enriches events from one or two MaxMind GeoIP2 databases: a
+// City (or Country) database for location, and an optional ASN database for
+// network ownership. A single Lookup call merges both into one record.
+type geoIP2Lookup struct {
+	city *geoip2.Reader
+	asn  *geoip2.Reader
+}
+
+func (l *geoIP2Lookup) Lookup(ip net.IP) *GeoIPRecord {
+	record := &GeoIPRecord{}
+	found := false
 
-	logp.Info("Loaded GeoIP data from: %s", geoipPath)
-	return geoLite
+	if l.city != nil {
+		city, err := l.city.City(ip)
+		if _, isCountryDB := err.(geoip2.InvalidMethodError); isCountryDB {
+			// A Country database can't answer City(); take what Country() has.
+			country, err := l.city.Country(ip)
+			if err != nil {
+				logp.Debug("geoip", "GeoIP2 country lookup failed for %s: %s", ip, err)
+			} else {
+				found = true
+				record.CountryCode = country.Country.IsoCode
+				record.CountryName = country.Country.Names["en"]
+			}
+		} else if err != nil {
+			logp.Debug("geoip", "GeoIP2 city lookup failed for %s: %s", ip, err)
+		} else {
+			found = true
+			record.CountryCode = city.Country.IsoCode
+			record.CountryName = city.Country.Names["en"]
+			record.City = city.City.Names["en"]
+			record.Latitude = city.Location.Latitude
+			record.Longitude = city.Location.Longitude
+			record.AccuracyRadius = city.Location.AccuracyRadius
+			for _, subdivision := range city.Subdivisions {
+				record.Subdivisions = append(record.Subdivisions, subdivision.Names["en"])
+			}
+		}
+	}
+
+	if l.asn != nil {
+		asn, err := l.asn.ASN(ip)
+		if err != nil {
+			logp.Debug("geoip", "GeoIP2 ASN lookup failed for %s: %s", ip, err)
+		} else {
+			found = true
+			record.ASN = asn.AutonomousSystemNumber
+			record.Organization = asn.AutonomousSystemOrganization
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return record
 }
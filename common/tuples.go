@@ -11,6 +11,33 @@ import (
 // We're introducing the HashableIpPortTuple and the HashableTcpTuple
 // types which are internally simple byte arrays.
 
+// TupleLookupObserver lets a caller holding a map keyed by Hashable() or
+// RevHashable() report whether a given lookup hit or missed, without this
+// package taking a dependency on a specific metrics backend (e.g.
+// Prometheus). Install one with SetTupleLookupObserver.
+type TupleLookupObserver interface {
+	ObserveTupleLookup(hit bool)
+}
+
+var tupleLookupObserver TupleLookupObserver
+
+// SetTupleLookupObserver installs the observer used by ObserveTupleLookup.
+// Pass nil to disable observation.
+func SetTupleLookupObserver(observer TupleLookupObserver) {
+	tupleLookupObserver = observer
+}
+
+// ObserveTupleLookup reports whether a map lookup keyed by a tuple's
+// Hashable()/RevHashable() value hit or missed. Tuple construction doesn't
+// know this itself, since the lookup happens in the caller's map access, so
+// callers that track hits/misses should call this right after indexing
+// their table.
+func ObserveTupleLookup(hit bool) {
+	if tupleLookupObserver != nil {
+		tupleLookupObserver.ObserveTupleLookup(hit)
+	}
+}
+
 const MaxIpPortTupleRawSize = 16 + 16 + 2 + 2
 
 type HashableIpPortTuple [MaxIpPortTupleRawSize]byte
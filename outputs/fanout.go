@@ -0,0 +1,117 @@
+package outputs
+
+import (
+	"time"
+
+	"github.com/elastic/libbeat/common"
+	"github.com/elastic/libbeat/logp"
+)
+
+// NamedOutput pairs a configured output with the name it was registered
+// under, so MultiOutput can report which sink failed and target the right
+// one again on Reload.
+type NamedOutput struct {
+	Name   string
+	Output Interface
+}
+
+// MultiOutput fans every event out to a set of independently-configured
+// outputs (e.g. file + redis + elasticsearch at once). A failing sink is
+// logged and doesn't stop the others from receiving the event.
+//
+// It's registered under the name "multi" like any other output, built from
+// config.Outputs: a map from a registered output name (see Register) to
+// that output's own Config. A sink missing Enabled: true in its own config
+// is skipped, which is how per-output enable flags work.
+type MultiOutput struct {
+	Outputs []NamedOutput
+}
+
+func init() {
+	Register("multi", func() Interface { return &MultiOutput{} })
+}
+
+// NewMultiOutput builds a MultiOutput directly from already-initialized
+// outputs, for callers that construct their sub-outputs themselves instead
+// of going through config.Outputs and Init.
+func NewMultiOutput(outs []NamedOutput) *MultiOutput {
+	return &MultiOutput{Outputs: outs}
+}
+
+func (m *MultiOutput) Init(config Config, topologyExpire int) error {
+	var outs []NamedOutput
+	for name, sub := range config.Outputs {
+		if !sub.Enabled {
+			continue
+		}
+
+		output, err := New(name)
+		if err != nil {
+			return err
+		}
+		if err := output.Init(sub, topologyExpire); err != nil {
+			return err
+		}
+
+		outs = append(outs, NamedOutput{Name: name, Output: output})
+	}
+
+	m.Outputs = outs
+	return nil
+}
+
+func (m *MultiOutput) PublishEvent(ts time.Time, event common.MapStr) error {
+	var firstErr error
+	for _, o := range m.Outputs {
+		if err := o.Output.PublishEvent(ts, event); err != nil {
+			logp.Err("[%s] Fail to publish event: %s", o.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (m *MultiOutput) PublishIPs(name string, localAddrs []string) error {
+	var firstErr error
+	for _, o := range m.Outputs {
+		if err := o.Output.PublishIPs(name, localAddrs); err != nil {
+			logp.Err("[%s] Fail to publish IPs: %s", o.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (m *MultiOutput) GetNameByIP(ip string) string {
+	for _, o := range m.Outputs {
+		if name := o.Output.GetNameByIP(ip); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// Reload dispatches each entry in config.Outputs to the already-running
+// sink registered under that name. A name in config.Outputs that MultiOutput
+// isn't currently running is ignored: outputs can only be added or removed
+// by restarting, Reload only changes the settings of ones already running.
+func (m *MultiOutput) Reload(config Config) error {
+	var firstErr error
+	for _, o := range m.Outputs {
+		sub, found := config.Outputs[o.Name]
+		if !found {
+			continue
+		}
+		if err := o.Output.Reload(sub); err != nil {
+			logp.Err("[%s] Fail to reload config: %s", o.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,71 @@
+package fileout
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionType selects how a rotated-away file is compressed.
+type CompressionType string
+
+const (
+	CompressionNone CompressionType = ""
+	CompressionGzip CompressionType = "gzip"
+	CompressionZstd CompressionType = "zstd"
+)
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func zstdFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".zst")
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	enc, err := zstd.NewWriter(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(enc, src); err != nil {
+		enc.Close()
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -1,19 +1,96 @@
 package fileout
 
 import (
-	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/elastic/libbeat/common"
 	"github.com/elastic/libbeat/logp"
 	"github.com/elastic/libbeat/outputs"
+	"github.com/elastic/libbeat/outputs/metrics"
+	"github.com/elastic/libbeat/outputs/spool"
 )
 
+// writer is the subset of logp.FileRotator and timeRotator that
+// FileOutput actually writes through.
+type writer interface {
+	WriteLine(line []byte) error
+	CreateDirectory() error
+}
+
+// framedWriter is implemented by writers that can emit length-prefixed
+// binary records (currently only timeRotator). logp.FileRotator's
+// byte-size rotation has no such method, so FormatMsgPack requires
+// RotateInterval to be set.
+type framedWriter interface {
+	WriteFramed(data []byte) error
+}
+
 type FileOutput struct {
 	rotator logp.FileRotator
+	timer   *timeRotator
+	writer  writer
+	format  Format
+	spool   *spool.BufferedPublisher
+}
+
+func init() {
+	outputs.Register("file", func() outputs.Interface { return &FileOutput{} })
 }
 
 func (out *FileOutput) Init(config outputs.Config, topologyExpire int) error {
+	if err := out.configureWriter(config); err != nil {
+		return err
+	}
+
+	if config.SpoolPath != "" {
+		publisher, err := spool.NewBufferedPublisher(directFileSink{out}, spool.Config{
+			Path:           config.SpoolPath,
+			MaxSizeBytes:   config.SpoolMaxSizeBytes,
+			FlushBatchSize: config.SpoolFlushBatch,
+		})
+		if err != nil {
+			return err
+		}
+		out.spool = publisher
+	}
+
+	return nil
+}
+
+// configureWriter picks between the byte-rotating logp.FileRotator (the
+// default, unchanged behavior) and the time-rotating, compression-capable
+// timeRotator, based on whether config.RotateInterval is set, and applies
+// the rest of config to whichever one is in use.
+func (out *FileOutput) configureWriter(config outputs.Config) error {
+	out.format = Format(config.Format)
+
+	if out.format == FormatMsgPack && config.RotateInterval == "" {
+		return errors.New("msgpack format requires RotateInterval to be set; byte-based rotation can't frame binary records safely")
+	}
+
+	if config.RotateInterval != "" {
+		interval, err := RotateInterval(config.RotateInterval).duration()
+		if err != nil {
+			return err
+		}
+
+		namePattern := config.FilenamePattern
+		if namePattern == "" {
+			namePattern = "packetbeat-%Y-%m-%d.json"
+		}
+
+		out.timer = &timeRotator{
+			Path:        config.Path,
+			NamePattern: namePattern,
+			RotateEvery: interval,
+			Compression: CompressionType(config.Compression),
+		}
+		out.writer = out.timer
+
+		return out.writer.CreateDirectory()
+	}
+
 	out.rotator.Path = config.Path
 	out.rotator.Name = config.Filename
 	if out.rotator.Name == "" {
@@ -31,18 +108,21 @@ func (out *FileOutput) Init(config outputs.Config, topologyExpire int) error {
 		keepfiles = 7
 	}
 	out.rotator.KeepFiles = &keepfiles
+	out.writer = &out.rotator
 
-	err := out.rotator.CreateDirectory()
-	if err != nil {
+	if err := out.rotator.CreateDirectory(); err != nil {
 		return err
 	}
 
-	err = out.rotator.CheckIfConfigSane()
-	if err != nil {
-		return err
-	}
+	return out.rotator.CheckIfConfigSane()
+}
 
-	return nil
+// Reload re-applies config to the writer, e.g. to point at a new path or
+// filename, switch rotation strategy, or change the rotation thresholds.
+// The change takes effect on the next write, which rotates into a file
+// under the new settings.
+func (out *FileOutput) Reload(config outputs.Config) error {
+	return out.configureWriter(config)
 }
 
 func (out *FileOutput) PublishIPs(name string, localAddrs []string) error {
@@ -56,17 +136,49 @@ func (out *FileOutput) GetNameByIP(ip string) string {
 }
 
 func (out *FileOutput) PublishEvent(ts time.Time, event common.MapStr) error {
+	if out.spool != nil {
+		return out.spool.PublishEvent(ts, event)
+	}
+	return out.publishEvent(ts, event)
+}
+
+func (out *FileOutput) publishEvent(ts time.Time, event common.MapStr) error {
+	start := time.Now()
+	defer func() {
+		metrics.PublishLatency.WithLabelValues("file").Observe(time.Since(start).Seconds())
+	}()
 
-	json_event, err := json.Marshal(event)
+	encoded, err := encodeEvent(out.format, event)
 	if err != nil {
-		logp.Err("Fail to convert the event to JSON: %s", err)
+		logp.Err("Fail to encode the event: %s", err)
 		return err
 	}
 
-	err = out.rotator.WriteLine(json_event)
-	if err != nil {
+	if out.format == FormatMsgPack {
+		framed, ok := out.writer.(framedWriter)
+		if !ok {
+			return errors.New("msgpack format requires a framing-capable writer")
+		}
+		if err := framed.WriteFramed(encoded); err != nil {
+			return err
+		}
+	} else if err := out.writer.WriteLine(encoded); err != nil {
 		return err
 	}
 
+	metrics.EventsPublished.WithLabelValues("file").Inc()
+	metrics.BytesWritten.WithLabelValues("file").Add(float64(len(encoded)))
+
 	return nil
 }
+
+// directFileSink adapts FileOutput's unspooled write path to spool.Sink, so
+// BufferedPublisher can replay acked events straight to disk without
+// re-entering the spool.
+type directFileSink struct {
+	out *FileOutput
+}
+
+func (d directFileSink) PublishEvent(ts time.Time, event common.MapStr) error {
+	return d.out.publishEvent(ts, event)
+}
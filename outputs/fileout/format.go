@@ -0,0 +1,35 @@
+package fileout
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/elastic/libbeat/common"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Format selects how an event is encoded before being written to disk.
+type Format string
+
+const (
+	// FormatJSON and FormatNDJSON both write one JSON object per line;
+	// they're kept as distinct names because NDJSON is the name operators
+	// look for when pointing log-shipping tools at the output.
+	FormatJSON   Format = "json"
+	FormatNDJSON Format = "ndjson"
+	// FormatMsgPack trades human-readability for a smaller, faster-to-parse
+	// encoding, useful for high-volume packetbeat captures.
+	FormatMsgPack Format = "msgpack"
+)
+
+func encodeEvent(format Format, event common.MapStr) ([]byte, error) {
+	switch format {
+	case "", FormatJSON, FormatNDJSON:
+		return json.Marshal(event)
+	case FormatMsgPack:
+		return msgpack.Marshal(event)
+	default:
+		return nil, fmt.Errorf("Unknown file output format: %s", format)
+	}
+}
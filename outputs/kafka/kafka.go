@@ -0,0 +1,346 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/libbeat/common"
+	"github.com/elastic/libbeat/logp"
+	"github.com/elastic/libbeat/outputs"
+	"github.com/elastic/libbeat/outputs/metrics"
+	"github.com/elastic/libbeat/outputs/spool"
+
+	"github.com/Shopify/sarama"
+)
+
+type KafkaOutput struct {
+	Topic   string
+	Brokers []string
+
+	ReconnectInterval time.Duration
+
+	TopologyExpire time.Duration
+	TopologyMap    map[string]string
+
+	kafkaConfig  *sarama.Config
+	sendingQueue chan *sarama.ProducerMessage
+	spool        *spool.BufferedPublisher
+
+	// mu guards Producer, syncProducer and connected, which Reload can
+	// swap out from under SendMessagesGoroutine/publishEventSync at any
+	// time.
+	mu           sync.Mutex
+	Producer     sarama.AsyncProducer
+	syncProducer sarama.SyncProducer
+	connected    bool
+}
+
+func init() {
+	outputs.Register("kafka", func() outputs.Interface { return &KafkaOutput{} })
+}
+
+func (out *KafkaOutput) Init(config outputs.Config, topology_expire int) error {
+
+	exp_sec := 15
+	if topology_expire != 0 {
+		exp_sec = topology_expire
+	}
+	out.TopologyExpire = time.Duration(exp_sec) * time.Second
+
+	if err := out.configure(config); err != nil {
+		return err
+	}
+
+	out.sendingQueue = make(chan *sarama.ProducerMessage, 1000)
+
+	out.Reconnect()
+	go out.SendMessagesGoroutine()
+
+	if config.SpoolPath != "" {
+		var err error
+		out.spool, err = spool.NewBufferedPublisher(directKafkaSink{out}, spool.Config{
+			Path:           config.SpoolPath,
+			MaxSizeBytes:   config.SpoolMaxSizeBytes,
+			FlushBatchSize: config.SpoolFlushBatch,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configure builds the sarama.Config and derived fields from config. It's
+// shared by Init and Reload; Reload leaves TopologyExpire and the
+// sendingQueue/spool plumbing untouched, and reconnects the producer
+// afterwards to pick up the new brokers/topic.
+func (out *KafkaOutput) configure(config outputs.Config) error {
+
+	if len(config.Brokers) == 0 {
+		return errors.New("No Kafka brokers configured")
+	}
+	out.Brokers = config.Brokers
+
+	out.Topic = config.Topic
+	if out.Topic == "" {
+		out.Topic = "packetbeat"
+	}
+
+	out.ReconnectInterval = time.Duration(1) * time.Second
+	if config.ReconnectInterval != 0 {
+		out.ReconnectInterval = time.Duration(config.ReconnectInterval) * time.Second
+	}
+
+	kafkaConfig := sarama.NewConfig()
+
+	switch config.Partitioner {
+	case "", "hash":
+		kafkaConfig.Producer.Partitioner = sarama.NewHashPartitioner
+	case "random":
+		kafkaConfig.Producer.Partitioner = sarama.NewRandomPartitioner
+	case "roundrobin":
+		kafkaConfig.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	default:
+		return errors.New("Bad Kafka partitioner")
+	}
+
+	switch config.Compression {
+	case "", "none":
+		kafkaConfig.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		kafkaConfig.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		kafkaConfig.Producer.Compression = sarama.CompressionSnappy
+	default:
+		return errors.New("Bad Kafka compression codec")
+	}
+
+	kafkaConfig.Producer.RequiredAcks = sarama.WaitForLocal
+	if config.RequiredAcks != nil {
+		kafkaConfig.Producer.RequiredAcks = sarama.RequiredAcks(*config.RequiredAcks)
+	}
+
+	if config.MaxRetries != nil {
+		kafkaConfig.Producer.Retry.Max = *config.MaxRetries
+	}
+
+	kafkaConfig.Producer.Return.Successes = false
+	kafkaConfig.Producer.Return.Errors = true
+
+	out.kafkaConfig = kafkaConfig
+
+	out.mu.Lock()
+	if out.syncProducer != nil {
+		out.syncProducer.Close()
+		out.syncProducer = nil
+	}
+	out.mu.Unlock()
+
+	logp.Info("[KafkaOutput] Using Kafka brokers %s", strings.Join(out.Brokers, ","))
+	logp.Info("[KafkaOutput] Using topic %s", out.Topic)
+	logp.Info("[KafkaOutput] Using %s partitioner", config.Partitioner)
+	logp.Info("[KafkaOutput] Using %s compression", config.Compression)
+
+	return nil
+}
+
+// Reload reconfigures the producer, e.g. to switch brokers or topic, and
+// reconnects using the new settings. The existing sendingQueue and any
+// configured spool keep running against the new producer. connected is
+// cleared before the old producer is closed so SendMessagesGoroutine stops
+// handing it messages instead of racing the close.
+func (out *KafkaOutput) Reload(config outputs.Config) error {
+	out.mu.Lock()
+	oldProducer := out.Producer
+	out.connected = false
+	out.Producer = nil
+	out.mu.Unlock()
+
+	if oldProducer != nil {
+		oldProducer.Close()
+	}
+
+	if err := out.configure(config); err != nil {
+		return err
+	}
+	go out.Reconnect()
+	return nil
+}
+
+func (out *KafkaOutput) Connect() error {
+	producer, err := sarama.NewAsyncProducer(out.Brokers, out.kafkaConfig)
+	if err != nil {
+		return err
+	}
+
+	syncConfig := *out.kafkaConfig
+	syncConfig.Producer.Return.Successes = true
+	syncProducer, err := sarama.NewSyncProducer(out.Brokers, &syncConfig)
+	if err != nil {
+		producer.Close()
+		return err
+	}
+
+	out.mu.Lock()
+	out.Producer = producer
+	out.syncProducer = syncProducer
+	out.connected = true
+	out.mu.Unlock()
+
+	go out.reportErrors(producer)
+
+	return nil
+}
+
+func (out *KafkaOutput) Reconnect() {
+	for {
+		metrics.Reconnects.WithLabelValues("kafka").Inc()
+
+		err := out.Connect()
+		if err != nil {
+			logp.Warn("Error connecting to Kafka (%s). Retrying in %s", err, out.ReconnectInterval)
+			time.Sleep(out.ReconnectInterval)
+		} else {
+			break
+		}
+	}
+}
+
+func (out *KafkaOutput) Close() {
+	producer, syncProducer, _ := out.getProducer()
+	if producer != nil {
+		producer.Close()
+	}
+	if syncProducer != nil {
+		syncProducer.Close()
+	}
+}
+
+// getProducer returns the currently-active producers and connected flag
+// under out.mu, so callers don't read a Producer that Reload is concurrently
+// closing out from under them.
+func (out *KafkaOutput) getProducer() (sarama.AsyncProducer, sarama.SyncProducer, bool) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	return out.Producer, out.syncProducer, out.connected
+}
+
+func (out *KafkaOutput) SendMessagesGoroutine() {
+	for queueMsg := range out.sendingQueue {
+		metrics.QueueDepth.WithLabelValues("kafka").Set(float64(len(out.sendingQueue)))
+
+		producer, _, connected := out.getProducer()
+		if !connected {
+			logp.Debug("output_kafka", "Droping pkt ...")
+			metrics.EventsDropped.WithLabelValues("kafka").Inc()
+			continue
+		}
+		producer.Input() <- queueMsg
+	}
+}
+
+// reportErrors drains producer's Errors channel for as long as producer is
+// the active one. It's tied to a specific producer instance (started by
+// Connect right after that producer is created) rather than reading
+// out.Producer, since Reload swaps that field out and the old producer's
+// Errors channel closes on its own once it's closed.
+func (out *KafkaOutput) reportErrors(producer sarama.AsyncProducer) {
+	for err := range producer.Errors() {
+		logp.Err("Fail to publish event to Kafka: %s", err.Err)
+		metrics.EventsDropped.WithLabelValues("kafka").Inc()
+	}
+}
+
+func (out *KafkaOutput) GetNameByIP(ip string) string {
+	name, exists := out.TopologyMap[ip]
+	if !exists {
+		return ""
+	}
+	return name
+}
+
+func (out *KafkaOutput) PublishIPs(name string, localAddrs []string) error {
+	// topology is not stored in Kafka, nothing to do here
+	return nil
+}
+
+func (out *KafkaOutput) PublishEvent(ts time.Time, event common.MapStr) error {
+	if out.spool != nil {
+		return out.spool.PublishEvent(ts, event)
+	}
+	return out.publishEvent(ts, event)
+}
+
+func (out *KafkaOutput) publishEvent(ts time.Time, event common.MapStr) error {
+	start := time.Now()
+	defer func() {
+		metrics.PublishLatency.WithLabelValues("kafka").Observe(time.Since(start).Seconds())
+	}()
+
+	json_event, err := json.Marshal(event)
+	if err != nil {
+		logp.Err("Fail to convert the event to JSON: %s", err)
+		return err
+	}
+
+	out.sendingQueue <- &sarama.ProducerMessage{
+		Topic: out.Topic,
+		Value: sarama.ByteEncoder(json_event),
+	}
+	metrics.EventsPublished.WithLabelValues("kafka").Inc()
+	metrics.BytesWritten.WithLabelValues("kafka").Add(float64(len(json_event)))
+
+	logp.Debug("output_kafka", "Publish event")
+	return nil
+}
+
+// directKafkaSink adapts KafkaOutput's unspooled send path to spool.Sink.
+// Unlike publishEvent, it must not return until Kafka has actually
+// acknowledged the message, since BufferedPublisher acks (and discards) the
+// spooled entry as soon as this returns nil; handing the message to
+// sendingQueue and returning isn't a real delivery confirmation.
+type directKafkaSink struct {
+	out *KafkaOutput
+}
+
+func (d directKafkaSink) PublishEvent(ts time.Time, event common.MapStr) error {
+	return d.out.publishEventSync(ts, event)
+}
+
+// publishEventSync sends event through a sarama.SyncProducer and only
+// returns once Kafka has acknowledged it.
+func (out *KafkaOutput) publishEventSync(ts time.Time, event common.MapStr) error {
+	start := time.Now()
+	defer func() {
+		metrics.PublishLatency.WithLabelValues("kafka").Observe(time.Since(start).Seconds())
+	}()
+
+	_, syncProducer, connected := out.getProducer()
+	if !connected || syncProducer == nil {
+		return errors.New("not connected to Kafka")
+	}
+
+	json_event, err := json.Marshal(event)
+	if err != nil {
+		logp.Err("Fail to convert the event to JSON: %s", err)
+		return err
+	}
+
+	_, _, err = syncProducer.SendMessage(&sarama.ProducerMessage{
+		Topic: out.Topic,
+		Value: sarama.ByteEncoder(json_event),
+	})
+	if err != nil {
+		logp.Err("Fail to publish event to Kafka: %s", err)
+		return err
+	}
+
+	metrics.EventsPublished.WithLabelValues("kafka").Inc()
+	metrics.BytesWritten.WithLabelValues("kafka").Add(float64(len(json_event)))
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/elastic/libbeat/outputs"
+)
+
+// TestConfigureRacesWithGetProducer exercises the same hazard Reload
+// introduces in production: configure (shared by Init and Reload) closes
+// out.syncProducer and rebuilds out.kafkaConfig while SendMessagesGoroutine
+// and publishEventSync are concurrently reading out.Producer/syncProducer/
+// connected through getProducer(). It drives configure directly rather
+// than Reload, since Reload's Connect step dials real brokers; the mutex
+// under test guards the same fields either way. Run with -race to catch
+// any access that bypasses out.mu.
+func TestConfigureRacesWithGetProducer(t *testing.T) {
+	out := &KafkaOutput{}
+	config := outputs.Config{Brokers: []string{"broker-a:9092"}}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				out.getProducer()
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := out.configure(config); err != nil {
+			t.Fatalf("configure: %s", err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,131 @@
+// Package metrics exposes Prometheus counters/histograms for the output
+// plugins and for common.IpPortTuple/TcpTuple hash-table lookups, so
+// operators can scrape a packetbeat the same way they scrape node_exporter.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/elastic/libbeat/common"
+	"github.com/elastic/libbeat/logp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	EventsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "packetbeat",
+		Subsystem: "output",
+		Name:      "events_published_total",
+		Help:      "Events successfully handed off to the output backend.",
+	}, []string{"output"})
+
+	EventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "packetbeat",
+		Subsystem: "output",
+		Name:      "events_dropped_total",
+		Help:      "Events discarded because the output backend wasn't connected or reported a publish error.",
+	}, []string{"output"})
+
+	BytesWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "packetbeat",
+		Subsystem: "output",
+		Name:      "bytes_written_total",
+		Help:      "Bytes of encoded events handed off to the output backend.",
+	}, []string{"output"})
+
+	PublishLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "packetbeat",
+		Subsystem: "output",
+		Name:      "publish_latency_seconds",
+		Help:      "Time spent in an output's PublishEvent call.",
+	}, []string{"output"})
+
+	Reconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "packetbeat",
+		Subsystem: "output",
+		Name:      "reconnects_total",
+		Help:      "Reconnect attempts made to the output backend.",
+	}, []string{"output"})
+
+	QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "packetbeat",
+		Subsystem: "output",
+		Name:      "queue_depth",
+		Help:      "Events currently buffered in an output's in-memory sending queue.",
+	}, []string{"output"})
+
+	TupleLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "packetbeat",
+		Subsystem: "tuple",
+		Name:      "lookups_total",
+		Help:      "IpPortTuple/TcpTuple hash-table lookups, by whether they hit.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		EventsPublished,
+		EventsDropped,
+		BytesWritten,
+		PublishLatency,
+		Reconnects,
+		QueueDepth,
+		TupleLookups,
+	)
+
+	common.SetTupleLookupObserver(tupleObserver{})
+}
+
+type tupleObserver struct{}
+
+func (tupleObserver) ObserveTupleLookup(hit bool) {
+	if hit {
+		TupleLookups.WithLabelValues("hit").Inc()
+	} else {
+		TupleLookups.WithLabelValues("miss").Inc()
+	}
+}
+
+// Config controls the Prometheus /metrics listener. It's meant to be
+// embedded as a top-level "Monitoring" block in a beat's config file,
+// alongside the Output block.
+type Config struct {
+	Enabled bool
+	Host    string
+	Port    int
+}
+
+// Serve starts an HTTP server exposing the registered collectors at
+// /metrics if config.Enabled, e.g. on :9100. It returns immediately; the
+// listener runs in the background for the lifetime of the process.
+func Serve(config Config) error {
+	if !config.Enabled {
+		return nil
+	}
+
+	host := config.Host
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	port := config.Port
+	if port == 0 {
+		port = 9100
+	}
+	addr := fmt.Sprintf("%s:%d", host, port)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	logp.Info("Starting Prometheus metrics listener on %s", addr)
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logp.Err("Prometheus metrics listener on %s stopped: %s", addr, err)
+		}
+	}()
+
+	return nil
+}
@@ -28,6 +28,27 @@ type Config struct {
 	FlushInterval     *int
 	BulkSize          *int
 	MaxRetries        *int
+	Brokers           []string
+	Topic             string
+	Partitioner       string
+	Compression       string
+	RequiredAcks      *int
+	URI               string
+	SentinelMaster    string
+	TLSEnable         bool
+	TLSInsecure       bool
+	SpoolPath         string
+	SpoolMaxSizeBytes int64
+	SpoolFlushBatch   int
+	Format            string
+	RotateInterval    string
+	FilenamePattern   string
+
+	// Outputs is used only by the "multi" output (see MultiOutput): a map
+	// from a registered output name to that output's own Config, so each
+	// sink can carry its own Enabled flag and settings independently of the
+	// others.
+	Outputs map[string]Config
 }
 
 // Functions to be exported by a output plugin
@@ -43,6 +64,11 @@ type Interface interface {
 
 	// Publish event
 	PublishEvent(ts time.Time, event common.MapStr) error
+
+	// Reload applies a new Config to an already-initialized output, e.g. to
+	// rotate files, reconnect with new credentials or switch index
+	// patterns, without restarting the beat.
+	Reload(config Config) error
 }
 
 // Output identifier
@@ -54,6 +80,7 @@ const (
 	RedisOutput
 	ElasticsearchOutput
 	FileOutput
+	KafkaOutput
 )
 
 // Output names
@@ -62,6 +89,7 @@ var OutputNames = []string{
 	"redis",
 	"elasticsearch",
 	"file",
+	"kafka",
 }
 
 func (o OutputPlugin) String() string {
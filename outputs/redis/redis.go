@@ -1,17 +1,22 @@
 package redis
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/elastic/libbeat/common"
 	"github.com/elastic/libbeat/logp"
 	"github.com/elastic/libbeat/outputs"
+	"github.com/elastic/libbeat/outputs/metrics"
+	"github.com/elastic/libbeat/outputs/spool"
 
-	"github.com/garyburd/redigo/redis"
+	"github.com/go-redis/redis/v8"
 )
 
 type RedisDataType uint16
@@ -23,12 +28,10 @@ const (
 
 type RedisOutput struct {
 	Index string
-	Conn  redis.Conn
 
 	TopologyExpire     time.Duration
 	ReconnectInterval  time.Duration
-	Hostname           string
-	Password           string
+	URI                string
 	Db                 int
 	DbTopology         int
 	Timeout            time.Duration
@@ -38,7 +41,14 @@ type RedisOutput struct {
 
 	TopologyMap  map[string]string
 	sendingQueue chan RedisQueueMsg
-	connected    bool
+	spool        *spool.BufferedPublisher
+
+	// mu guards Client and connected, which Reload's configure() can swap
+	// out from under SendMessagesGoroutine and the direct publish/topology
+	// paths at any time.
+	mu        sync.Mutex
+	Client    redis.UniversalClient
+	connected bool
 }
 
 type RedisQueueMsg struct {
@@ -46,12 +56,51 @@ type RedisQueueMsg struct {
 	msg   string
 }
 
+func init() {
+	outputs.Register("redis", func() outputs.Interface { return &RedisOutput{} })
+}
+
 func (out *RedisOutput) Init(config outputs.Config, topology_expire int) error {
 
-	out.Hostname = fmt.Sprintf("%s:%d", config.Host, config.Port)
+	exp_sec := 15
+	if topology_expire != 0 {
+		exp_sec = topology_expire
+	}
+	out.TopologyExpire = time.Duration(exp_sec) * time.Second
+
+	if err := out.configure(config); err != nil {
+		return err
+	}
+
+	out.sendingQueue = make(chan RedisQueueMsg, 1000)
+
+	out.Reconnect()
+	go out.SendMessagesGoroutine()
+
+	if config.SpoolPath != "" {
+		var err error
+		out.spool, err = spool.NewBufferedPublisher(directRedisSink{out}, spool.Config{
+			Path:           config.SpoolPath,
+			MaxSizeBytes:   config.SpoolMaxSizeBytes,
+			FlushBatchSize: config.SpoolFlushBatch,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configure applies the connection-related parts of config: URI, DB
+// selection, timeouts, index pattern and the underlying
+// redis.UniversalClient. It's shared by Init and Reload; Reload leaves
+// TopologyExpire and the sendingQueue/spool plumbing untouched.
+func (out *RedisOutput) configure(config outputs.Config) error {
 
-	if config.Password != "" {
-		out.Password = config.Password
+	out.URI = config.URI
+	if out.URI == "" {
+		out.URI = fmt.Sprintf("redis://%s:%d", config.Host, config.Port)
 	}
 
 	if config.Db != 0 {
@@ -62,6 +111,9 @@ func (out *RedisOutput) Init(config outputs.Config, topology_expire int) error {
 	if config.DbTopology != 0 {
 		out.DbTopology = config.DbTopology
 	}
+	// redis.UniversalClient only selects one DB at dial time, so topology
+	// data is stored alongside events in Db, in every mode (standalone,
+	// Sentinel and Cluster); DbTopology is not used for storage.
 
 	out.Timeout = 5 * time.Second
 	if config.Timeout != 0 {
@@ -89,12 +141,6 @@ func (out *RedisOutput) Init(config outputs.Config, topology_expire int) error {
 		out.ReconnectInterval = time.Duration(config.ReconnectInterval) * time.Second
 	}
 
-	exp_sec := 15
-	if topology_expire != 0 {
-		exp_sec = topology_expire
-	}
-	out.TopologyExpire = time.Duration(exp_sec) * time.Second
-
 	switch config.DataType {
 	case "", "list":
 		out.DataType = RedisListType
@@ -104,10 +150,26 @@ func (out *RedisOutput) Init(config outputs.Config, topology_expire int) error {
 		return errors.New("Bad Redis data type")
 	}
 
-	logp.Info("[RedisOutput] Using Redis server %s", out.Hostname)
-	if out.Password != "" {
-		logp.Info("[RedisOutput] Using password to connect to Redis")
+	opts, err := buildUniversalOptions(out.URI, config)
+	if err != nil {
+		return err
+	}
+	opts.DB = out.Db
+	opts.DialTimeout = out.Timeout
+	opts.ReadTimeout = out.Timeout
+	opts.WriteTimeout = out.Timeout
+
+	newClient := redis.NewUniversalClient(opts)
+	out.mu.Lock()
+	oldClient := out.Client
+	out.Client = newClient
+	out.connected = false
+	out.mu.Unlock()
+	if oldClient != nil {
+		oldClient.Close()
 	}
+
+	logp.Info("[RedisOutput] Using Redis server(s) at %s", out.URI)
 	logp.Info("[RedisOutput] Redis connection timeout %s", out.Timeout)
 	logp.Info("[RedisOutput] Redis reconnect interval %s", out.ReconnectInterval)
 	logp.Info("[RedisOutput] Redis flushing interval %s", out.FlushInterval)
@@ -117,62 +179,123 @@ func (out *RedisOutput) Init(config outputs.Config, topology_expire int) error {
 	logp.Info("[RedisOutput] Using db %d for storing topology", out.DbTopology)
 	logp.Info("[RedisOutput] Using %d data type", out.DataType)
 
-	out.sendingQueue = make(chan RedisQueueMsg, 1000)
-
-	out.Reconnect()
-	go out.SendMessagesGoroutine()
+	return nil
+}
 
+// Reload reconnects to Redis using the new config, e.g. to fail over to a
+// different Sentinel master or pick up new credentials. The existing
+// sendingQueue and any configured spool keep running against the new
+// client.
+func (out *RedisOutput) Reload(config outputs.Config) error {
+	if err := out.configure(config); err != nil {
+		return err
+	}
+	go out.Reconnect()
 	return nil
 }
 
-func (out *RedisOutput) RedisConnect(db int) (redis.Conn, error) {
-	conn, err := redis.DialTimeout(
-		"tcp",
-		out.Hostname,
-		out.Timeout, out.Timeout, out.Timeout)
+// buildUniversalOptions translates a redis://, rediss://, redis-sentinel:// or
+// redis-cluster:// URI plus Sentinel/TLS config into options usable by
+// redis.NewUniversalClient. The scheme selects between a standalone client, a
+// Sentinel-aware failover client and a Cluster client, all driven through the
+// same RedisOutput code path.
+func buildUniversalOptions(uri string, config outputs.Config) (*redis.UniversalOptions, error) {
+	scheme, rest, err := splitSchemeAndRest(uri)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(out.Password) > 0 {
-		_, err = conn.Do("AUTH", out.Password)
-		if err != nil {
-			return nil, err
-		}
+	addrs := strings.Split(rest.hosts, ",")
+
+	opts := &redis.UniversalOptions{
+		Addrs:    addrs,
+		Password: rest.password,
+		Username: rest.username,
 	}
 
-	_, err = conn.Do("PING")
-	if err != nil {
-		return nil, err
+	if config.TLSEnable || scheme == "rediss" {
+		opts.TLSConfig = &tls.Config{InsecureSkipVerify: config.TLSInsecure}
 	}
 
-	_, err = conn.Do("SELECT", db)
-	if err != nil {
-		return nil, err
+	switch scheme {
+	case "redis", "rediss", "":
+		// standalone; redis.NewUniversalClient picks a simple Client
+		// when len(Addrs) == 1 and MasterName/ClusterSlots are unset.
+		if len(addrs) > 1 {
+			return nil, errors.New("Multiple addresses only supported with redis-sentinel:// or redis-cluster://")
+		}
+	case "redis-sentinel":
+		if config.SentinelMaster == "" {
+			return nil, errors.New("redis-sentinel:// requires SentinelMaster to be set")
+		}
+		opts.MasterName = config.SentinelMaster
+	case "redis-cluster":
+		// len(Addrs) > 1 makes redis.NewUniversalClient return a ClusterClient
+	default:
+		return nil, fmt.Errorf("Unsupported Redis URI scheme: %s", scheme)
 	}
 
-	return conn, nil
+	return opts, nil
 }
 
-func (out *RedisOutput) Connect() error {
-	var err error
-	out.Conn, err = out.RedisConnect(out.Db)
-	if err != nil {
-		return err
+type redisURIParts struct {
+	hosts    string
+	username string
+	password string
+}
+
+func splitSchemeAndRest(uri string) (scheme string, parts redisURIParts, err error) {
+	idx := strings.Index(uri, "://")
+	if idx < 0 {
+		return "", redisURIParts{hosts: uri}, nil
+	}
+	scheme = uri[:idx]
+	rest := uri[idx+3:]
+
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		userinfo := rest[:at]
+		rest = rest[at+1:]
+		if colon := strings.Index(userinfo, ":"); colon >= 0 {
+			parts.username = userinfo[:colon]
+			parts.password = userinfo[colon+1:]
+		} else {
+			parts.password = userinfo
+		}
 	}
-	out.connected = true
 
-	return nil
+	parts.hosts = rest
+	return scheme, parts, nil
 }
 
 func (out *RedisOutput) Close() {
-	out.Conn.Close()
+	client, _ := out.getClient()
+	if client != nil {
+		client.Close()
+	}
+}
+
+// getClient returns the currently-active client and connected flag under
+// out.mu, so callers don't read a Client that Reload's configure() is
+// concurrently swapping out from under them.
+func (out *RedisOutput) getClient() (redis.UniversalClient, bool) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	return out.Client, out.connected
+}
+
+func (out *RedisOutput) setConnected(connected bool) {
+	out.mu.Lock()
+	out.connected = connected
+	out.mu.Unlock()
 }
 
 func (out *RedisOutput) SendMessagesGoroutine() {
 
+	ctx := context.Background()
 	var err error
 	var pending int
+	client, _ := out.getClient()
+	pipe := client.Pipeline()
 	flushChannel := make(<-chan time.Time)
 
 	if !out.flush_immediatelly {
@@ -180,39 +303,62 @@ func (out *RedisOutput) SendMessagesGoroutine() {
 		flushChannel = flushTicker.C
 	}
 
+	// currentPipe returns the pipeline to queue commands on, rebuilding it
+	// whenever Reload has swapped out.Client out from under us. Any commands
+	// still pending on the old pipe are lost, same as any other command that
+	// was in flight against the connection Reload just closed.
+	currentPipe := func() redis.Pipeliner {
+		if current, _ := out.getClient(); current != client {
+			client = current
+			pipe = client.Pipeline()
+			pending = 0
+		}
+		return pipe
+	}
+
 	for {
 		select {
 		case queueMsg := <-out.sendingQueue:
 
-			if !out.connected {
+			metrics.QueueDepth.WithLabelValues("redis").Set(float64(len(out.sendingQueue)))
+
+			if _, connected := out.getClient(); !connected {
 				logp.Debug("output_redis", "Droping pkt ...")
+				metrics.EventsDropped.WithLabelValues("redis").Inc()
 				continue
 			}
 			logp.Debug("output_redis", "Send event to redis")
-			command := "RPUSH"
-			if out.DataType == RedisChannelType {
-				command = "PUBLISH"
-			}
 
-			if !out.flush_immediatelly {
-				err = out.Conn.Send(command, queueMsg.index, queueMsg.msg)
-				pending += 1
+			pipe = currentPipe()
+			if out.DataType == RedisChannelType {
+				pipe.Publish(ctx, queueMsg.index, queueMsg.msg)
 			} else {
-				_, err = out.Conn.Do(command, queueMsg.index, queueMsg.msg)
+				pipe.RPush(ctx, queueMsg.index, queueMsg.msg)
 			}
-			if err != nil {
-				logp.Err("Fail to publish event to REDIS: %s", err)
-				out.connected = false
-				go out.Reconnect()
+			pending += 1
+			metrics.BytesWritten.WithLabelValues("redis").Add(float64(len(queueMsg.msg)))
+
+			if out.flush_immediatelly {
+				_, err = pipe.Exec(ctx)
+				pending = 0
+				if err != nil {
+					logp.Err("Fail to publish event to REDIS: %s", err)
+					out.setConnected(false)
+					go out.Reconnect()
+				} else {
+					metrics.EventsPublished.WithLabelValues("redis").Inc()
+				}
 			}
 		case _ = <-flushChannel:
+			pipe = currentPipe()
 			if pending > 0 {
-				out.Conn.Flush()
-				_, err = out.Conn.Receive()
+				_, err = pipe.Exec(ctx)
 				if err != nil {
 					logp.Err("Fail to publish event to REDIS: %s", err)
-					out.connected = false
+					out.setConnected(false)
 					go out.Reconnect()
+				} else {
+					metrics.EventsPublished.WithLabelValues("redis").Add(float64(pending))
 				}
 				logp.Debug("output_redis", "Flushed %d pending commands", pending)
 				pending = 0
@@ -224,11 +370,17 @@ func (out *RedisOutput) SendMessagesGoroutine() {
 func (out *RedisOutput) Reconnect() {
 
 	for {
-		err := out.Connect()
+		metrics.Reconnects.WithLabelValues("redis").Inc()
+
+		client, _ := out.getClient()
+		ctx, cancel := context.WithTimeout(context.Background(), out.Timeout)
+		err := client.Ping(ctx).Err()
+		cancel()
 		if err != nil {
 			logp.Warn("Error connecting to Redis (%s). Retrying in %s", err, out.ReconnectInterval)
 			time.Sleep(out.ReconnectInterval)
 		} else {
+			out.setConnected(true)
 			break
 		}
 	}
@@ -246,41 +398,41 @@ func (out *RedisOutput) PublishIPs(name string, localAddrs []string) error {
 
 	logp.Debug("output_redis", "[%s] Publish the IPs %s", name, localAddrs)
 
-	// connect to db
-	conn, err := out.RedisConnect(out.DbTopology)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), out.Timeout)
+	defer cancel()
+
+	client, _ := out.getClient()
 
-	_, err = conn.Do("HSET", name, "ipaddrs", strings.Join(localAddrs, ","))
+	err := client.HSet(ctx, name, "ipaddrs", strings.Join(localAddrs, ",")).Err()
 	if err != nil {
 		logp.Err("[%s] Fail to set the IP addresses: %s", name, err)
 		return err
 	}
 
-	_, err = conn.Do("EXPIRE", name, int(out.TopologyExpire.Seconds()))
+	err = client.Expire(ctx, name, out.TopologyExpire).Err()
 	if err != nil {
 		logp.Err("[%s] Fail to set the expiration time: %s", name, err)
 		return err
 	}
 
-	out.UpdateLocalTopologyMap(conn)
+	out.UpdateLocalTopologyMap(ctx)
 
 	return nil
 }
 
-func (out *RedisOutput) UpdateLocalTopologyMap(conn redis.Conn) {
+func (out *RedisOutput) UpdateLocalTopologyMap(ctx context.Context) {
 
 	TopologyMapTmp := make(map[string]string)
 
-	hostnames, err := redis.Strings(conn.Do("KEYS", "*"))
+	client, _ := out.getClient()
+
+	hostnames, err := client.Keys(ctx, "*").Result()
 	if err != nil {
 		logp.Err("Fail to get the all shippers from the topology map %s", err)
 		return
 	}
 	for _, hostname := range hostnames {
-		res, err := redis.String(conn.Do("HGET", hostname, "ipaddrs"))
+		res, err := client.HGet(ctx, hostname, "ipaddrs").Result()
 		if err != nil {
 			logp.Err("[%s] Fail to get the IPs: %s", hostname, err)
 		} else {
@@ -297,6 +449,21 @@ func (out *RedisOutput) UpdateLocalTopologyMap(conn redis.Conn) {
 }
 
 func (out *RedisOutput) PublishEvent(ts time.Time, event common.MapStr) error {
+	if out.spool != nil {
+		return out.spool.PublishEvent(ts, event)
+	}
+	return out.PublishEventWithContext(context.Background(), ts, event)
+}
+
+// PublishEventWithContext behaves like PublishEvent but allows callers to
+// bound the enqueue with a context, e.g. to apply a publish deadline. It
+// bypasses the spool, if configured, and hands the event straight to
+// sendingQueue.
+func (out *RedisOutput) PublishEventWithContext(ctx context.Context, ts time.Time, event common.MapStr) error {
+	start := time.Now()
+	defer func() {
+		metrics.PublishLatency.WithLabelValues("redis").Observe(time.Since(start).Seconds())
+	}()
 
 	json_event, err := json.Marshal(event)
 	if err != nil {
@@ -304,8 +471,66 @@ func (out *RedisOutput) PublishEvent(ts time.Time, event common.MapStr) error {
 		return err
 	}
 
-	out.sendingQueue <- RedisQueueMsg{index: out.Index, msg: string(json_event)}
+	select {
+	case out.sendingQueue <- RedisQueueMsg{index: out.Index, msg: string(json_event)}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
 	logp.Debug("output_redis", "Publish event")
 	return nil
 }
+
+// directRedisSink adapts RedisOutput's unspooled send path to spool.Sink.
+// Unlike PublishEvent, it must not return until the event is actually
+// confirmed written to Redis, since BufferedPublisher acks (and discards)
+// the spooled entry as soon as this returns nil; handing the event to
+// sendingQueue and returning isn't a real delivery confirmation.
+type directRedisSink struct {
+	out *RedisOutput
+}
+
+func (d directRedisSink) PublishEvent(ts time.Time, event common.MapStr) error {
+	return d.out.publishEventSync(ts, event)
+}
+
+// publishEventSync writes event straight to Redis and waits for the
+// command to complete, bypassing sendingQueue/SendMessagesGoroutine so the
+// caller gets a real delivery confirmation.
+func (out *RedisOutput) publishEventSync(ts time.Time, event common.MapStr) error {
+	start := time.Now()
+	defer func() {
+		metrics.PublishLatency.WithLabelValues("redis").Observe(time.Since(start).Seconds())
+	}()
+
+	client, connected := out.getClient()
+	if !connected {
+		return errors.New("not connected to Redis")
+	}
+
+	json_event, err := json.Marshal(event)
+	if err != nil {
+		logp.Err("Fail to convert the event to JSON: %s", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), out.Timeout)
+	defer cancel()
+
+	if out.DataType == RedisChannelType {
+		err = client.Publish(ctx, out.Index, string(json_event)).Err()
+	} else {
+		err = client.RPush(ctx, out.Index, string(json_event)).Err()
+	}
+	if err != nil {
+		logp.Err("Fail to publish event to REDIS: %s", err)
+		out.setConnected(false)
+		go out.Reconnect()
+		return err
+	}
+
+	metrics.EventsPublished.WithLabelValues("redis").Inc()
+	metrics.BytesWritten.WithLabelValues("redis").Add(float64(len(json_event)))
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package redis
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/elastic/libbeat/outputs"
+)
+
+// TestConfigureRacesWithGetClient exercises the same hazard Reload
+// introduces in production: configure (shared by Init and Reload) swaps
+// out.Client and clears out.connected while SendMessagesGoroutine,
+// PublishIPs and publishEventSync are concurrently reading them through
+// getClient(). redis.NewUniversalClient doesn't dial, so configure is safe
+// to call directly here without a real server. Run with -race to catch any
+// access that bypasses out.mu.
+func TestConfigureRacesWithGetClient(t *testing.T) {
+	out := &RedisOutput{}
+	config := outputs.Config{Host: "localhost", Port: 6379}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				out.getClient()
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := out.configure(config); err != nil {
+			t.Fatalf("configure: %s", err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,23 @@
+package outputs
+
+import "fmt"
+
+// registry maps an output's config name (as used in OutputNames and beat
+// config files) to a factory for it. Register lets an output plug in by
+// name without the OutputPlugin enum knowing about it ahead of time, which
+// is what multi-output fan-out configs and third-party outputs need.
+var registry = map[string]func() Interface{}
+
+func Register(name string, factory func() Interface) {
+	registry[name] = factory
+}
+
+// New builds a fresh, uninitialized instance of the output registered under
+// name. Callers still need to call Init on the result.
+func New(name string) (Interface, error) {
+	factory, found := registry[name]
+	if !found {
+		return nil, fmt.Errorf("Unknown output plugin: %s", name)
+	}
+	return factory(), nil
+}
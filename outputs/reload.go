@@ -0,0 +1,68 @@
+package outputs
+
+import (
+	"github.com/elastic/libbeat/logp"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// Reloader watches a beat's config file on disk and calls Output.Reload
+// with a freshly parsed Config whenever it changes, so a running output can
+// pick up new credentials, index patterns or rotation settings without a
+// restart.
+type Reloader struct {
+	Output Interface
+	Path   string
+	Parse  func(path string) (Config, error)
+
+	watcher *fsnotify.Watcher
+}
+
+// NewReloader starts watching path and applies every change to output via
+// parse. The watch runs in a background goroutine until Close is called.
+func NewReloader(output Interface, path string, parse func(path string) (Config, error)) (*Reloader, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.Add(path); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	r := &Reloader{
+		Output:  output,
+		Path:    path,
+		Parse:   parse,
+		watcher: watcher,
+	}
+	go r.watchLoop()
+
+	return r, nil
+}
+
+func (r *Reloader) watchLoop() {
+	for event := range r.watcher.Events {
+		if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+			continue
+		}
+
+		config, err := r.Parse(r.Path)
+		if err != nil {
+			logp.Err("Fail to reload config from %s: %s", r.Path, err)
+			continue
+		}
+
+		if err := r.Output.Reload(config); err != nil {
+			logp.Err("Fail to apply reloaded config from %s: %s", r.Path, err)
+			continue
+		}
+
+		logp.Info("Reloaded output config from %s", r.Path)
+	}
+}
+
+func (r *Reloader) Close() error {
+	return r.watcher.Close()
+}
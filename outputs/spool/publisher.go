@@ -0,0 +1,132 @@
+package spool
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/elastic/libbeat/common"
+	"github.com/elastic/libbeat/logp"
+)
+
+// Sink is the narrow interface BufferedPublisher replays acked events
+// against. Outputs implement it with whatever does the actual network I/O,
+// keeping it distinct from outputs.Interface so PublishEvent on the output
+// itself can mean "spool it" without recursing into the sink.
+type Sink interface {
+	PublishEvent(ts time.Time, event common.MapStr) error
+}
+
+// Config controls a BufferedPublisher's on-disk queue.
+type Config struct {
+	Path           string
+	MaxSizeBytes   int64
+	FlushBatchSize int
+}
+
+// BufferedPublisher persists every event to a SpoolQueue before handing it
+// to Sink, and only removes it from disk once Sink has acked it. This gives
+// at-least-once delivery across crashes and backend outages, replaying
+// whatever was left on disk at startup.
+type BufferedPublisher struct {
+	Sink  Sink
+	Queue *SpoolQueue
+
+	batchSize int
+	done      chan struct{}
+}
+
+// NewBufferedPublisher opens (or resumes) the spool at config.Path and
+// starts replaying it against sink in the background.
+func NewBufferedPublisher(sink Sink, config Config) (*BufferedPublisher, error) {
+	queue, err := NewSpoolQueue(config.Path, config.MaxSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize := config.FlushBatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	publisher := &BufferedPublisher{
+		Sink:      sink,
+		Queue:     queue,
+		batchSize: batchSize,
+		done:      make(chan struct{}),
+	}
+
+	go publisher.replayLoop()
+
+	return publisher, nil
+}
+
+// PublishEvent spools event for later delivery. It returns as soon as the
+// event is durably on disk, not once it's actually been sent.
+func (p *BufferedPublisher) PublishEvent(ts time.Time, event common.MapStr) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logp.Err("Fail to convert the event to JSON: %s", err)
+		return err
+	}
+
+	_, err = p.Queue.Enqueue(data)
+	return err
+}
+
+func (p *BufferedPublisher) replayLoop() {
+	for {
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+
+		entries, err := p.Queue.Dequeue(p.batchSize)
+		if err != nil {
+			logp.Err("Fail to read spooled events: %s", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if len(entries) == 0 {
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+
+		var acked []uint64
+		for _, entry := range entries {
+			var event common.MapStr
+			if err := json.Unmarshal(entry.Data, &event); err != nil {
+				logp.Err("Dropping unparsable spooled event %d: %s", entry.Id, err)
+				acked = append(acked, entry.Id)
+				continue
+			}
+
+			if err := p.Sink.PublishEvent(time.Now(), event); err != nil {
+				logp.Warn("Fail to publish spooled event %d, will retry: %s", entry.Id, err)
+				continue
+			}
+
+			acked = append(acked, entry.Id)
+		}
+
+		if len(acked) > 0 {
+			if err := p.Queue.Ack(acked); err != nil {
+				logp.Err("Fail to ack spooled events: %s", err)
+			}
+		}
+
+		if len(acked) == 0 {
+			// Every entry in this batch failed to publish, so the backend
+			// is likely down. Back off instead of immediately re-dequeuing
+			// and retrying the same batch against a sink that just
+			// rejected it.
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func (p *BufferedPublisher) Close() error {
+	close(p.done)
+	return p.Queue.Close()
+}
@@ -0,0 +1,120 @@
+// Package spool implements a disk-backed, at-least-once queue shared by the
+// output plugins. Events are persisted before they're handed to a sink, and
+// only removed from disk once the sink has acked them, so a crash or a
+// backend outage can't silently drop traffic the way the old in-memory
+// RedisOutput.sendingQueue did.
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/boltdb/bolt"
+)
+
+var eventsBucket = []byte("events")
+
+// SpoolEntry is one persisted, not-yet-acked event.
+type SpoolEntry struct {
+	Id   uint64
+	Data []byte
+}
+
+// SpoolQueue is a durable FIFO queue backed by a single BoltDB file. It's
+// safe to share across goroutines.
+type SpoolQueue struct {
+	db      *bolt.DB
+	maxSize int64
+}
+
+// NewSpoolQueue opens (and creates, if necessary) the spool file at path.
+// maxSize bounds the on-disk size in bytes; Enqueue starts failing once the
+// file reaches it. A maxSize of 0 means unbounded.
+func NewSpoolQueue(path string, maxSize int64) (*SpoolQueue, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SpoolQueue{db: db, maxSize: maxSize}, nil
+}
+
+// Enqueue persists event and returns the id it was assigned.
+func (q *SpoolQueue) Enqueue(event []byte) (uint64, error) {
+	var id uint64
+
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventsBucket)
+
+		if q.maxSize > 0 && tx.Size() > q.maxSize {
+			return errors.New("spool queue is full")
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		id = seq
+
+		return bucket.Put(idToKey(id), event)
+	})
+
+	return id, err
+}
+
+// Dequeue returns up to n entries in FIFO order, without removing them.
+// Entries stay on disk until Ack is called, so a consumer that dies mid-batch
+// will see them again on the next Dequeue.
+func (q *SpoolQueue) Dequeue(n int) ([]SpoolEntry, error) {
+	var entries []SpoolEntry
+
+	err := q.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(eventsBucket).Cursor()
+
+		for k, v := cursor.First(); k != nil && len(entries) < n; k, v = cursor.Next() {
+			data := make([]byte, len(v))
+			copy(data, v)
+			entries = append(entries, SpoolEntry{Id: keyToId(k), Data: data})
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// Ack permanently removes the given entries from the queue.
+func (q *SpoolQueue) Ack(ids []uint64) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventsBucket)
+		for _, id := range ids {
+			if err := bucket.Delete(idToKey(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (q *SpoolQueue) Close() error {
+	return q.db.Close()
+}
+
+func idToKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func keyToId(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key)
+}